@@ -0,0 +1,87 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AgentTable is the name of the table AgentStatsWriter upserts into.
+const AgentTable = "AgentIntervalStats"
+
+// agentMetricNames are the duration-based per-agent metrics PureCloud reports, each stored as
+// a (sum, max, count) triple in columns tXXX, mtXXX, nXXX, same convention as
+// QueueIntervalRecord. AgentRoutingStatus and OrganizationPresence are time-in-status metrics;
+// the rest mirror their queue-side counterparts but scoped to a single agent.
+var agentMetricNames = []string{"AgentRoutingStatus", "OrganizationPresence", "Handle", "Talk", "Acw", "Hold"}
+
+// AgentIntervalRecord holds one (UserID, Interval) row of aggregated per-agent statistics,
+// ready to be upserted by an AgentStatsWriter.
+type AgentIntervalRecord struct {
+	UserID   string
+	UserName string
+	Interval time.Time
+
+	Metrics map[string]Metric // keyed by agentMetricNames
+}
+
+// agentColumns returns the non-key columns in a fixed order, matching the table schema.
+func agentColumns() []string {
+	cols := make([]string, 0, len(agentMetricNames)*3)
+	for _, name := range agentMetricNames {
+		cols = append(cols, "t"+name, "mt"+name, "n"+name)
+	}
+	return cols
+}
+
+// agentValues returns rec's non-key column values in the same order as agentColumns().
+func agentValues(rec AgentIntervalRecord) []interface{} {
+	vals := make([]interface{}, 0, len(agentMetricNames)*3)
+	for _, name := range agentMetricNames {
+		m := rec.Metrics[name]
+		vals = append(vals, m.Sum, m.Max, m.Count)
+	}
+	return vals
+}
+
+// AgentStatsWriter upserts agent interval statistics into a SQL database within a single
+// transaction shared by every call to WriteAgentInterval.
+type AgentStatsWriter struct {
+	db     *sql.DB
+	driver Driver
+	tx     *sql.Tx
+}
+
+// NewAgentStatsWriter returns an AgentStatsWriter that writes to db using the SQL dialect for driver.
+func NewAgentStatsWriter(db *sql.DB, driver Driver) *AgentStatsWriter {
+	return &AgentStatsWriter{db: db, driver: driver}
+}
+
+// Begin starts the transaction that every subsequent WriteAgentInterval call becomes part of.
+func (w *AgentStatsWriter) Begin() (err error) {
+	w.tx, err = w.db.Begin()
+	return
+}
+
+// Commit commits the transaction started by Begin.
+func (w *AgentStatsWriter) Commit() error {
+	return w.tx.Commit()
+}
+
+// Rollback rolls back the transaction started by Begin.
+func (w *AgentStatsWriter) Rollback() error {
+	return w.tx.Rollback()
+}
+
+// WriteAgentInterval upserts a single agent interval record, keyed on (UserID, Interval). It
+// must be called between Begin and Commit/Rollback.
+func (w *AgentStatsWriter) WriteAgentInterval(rec AgentIntervalRecord) error {
+	return upsert(w.tx, w.driver, row{
+		table:          AgentTable,
+		keyCols:        []string{"UserID", "Interval"},
+		keyVals:        []interface{}{rec.UserID, rec.Interval},
+		insertOnlyCols: []string{"UserName"},
+		insertOnlyVals: []interface{}{rec.UserName},
+		dataCols:       agentColumns(),
+		dataVals:       agentValues(rec),
+	})
+}
@@ -0,0 +1,134 @@
+// Package store writes aggregated PureCloud queue and agent interval statistics into a SQL
+// database. It owns the dialect differences between the supported drivers so that
+// callers only ever deal with a QueueIntervalRecord or AgentIntervalRecord and a transaction.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Driver identifies the SQL dialect to use when writing queue interval statistics.
+type Driver string
+
+// Supported drivers.
+const (
+	DriverODBC     Driver = "odbc"
+	DriverMSSQL    Driver = "mssql"
+	DriverPostgres Driver = "postgres"
+	DriverMySQL    Driver = "mysql"
+	DriverSQLite   Driver = "sqlite"
+)
+
+// DriverName returns the database/sql driver name to pass to sql.Open for d.
+func DriverName(d Driver) (string, error) {
+	switch d {
+	case DriverODBC:
+		return "odbc", nil
+	case DriverMSSQL:
+		return "sqlserver", nil
+	case DriverPostgres:
+		return "postgres", nil
+	case DriverMySQL:
+		return "mysql", nil
+	case DriverSQLite:
+		return "sqlite3", nil
+	default:
+		return "", fmt.Errorf("unsupported driver %q", d)
+	}
+}
+
+// Table is the name of the table StatsWriter upserts into.
+const Table = "QueueIntervalStats"
+
+// countColumns are the plain counters reported by PureCloud, stored as-is.
+var countColumns = []string{"nError", "nOffered", "nOutboundAbandoned", "nOutboundAttempted", "nOutboundConnected", "nTransferred", "nOverSla"}
+
+// metricNames are the duration-based PureCloud metrics, each stored as a (sum, max, count) triple
+// in columns tXXX, mtXXX, nXXX.
+var metricNames = []string{"Abandon", "Acd", "Acw", "AgentResponseTime", "Answered", "Handle", "Held", "HeldComplete", "Ivr", "Talk", "TalkComplete", "UserResponseTime"}
+
+// Metric holds the Sum, Max and Count PureCloud reports for a duration-based metric.
+type Metric struct {
+	Sum   float64
+	Max   float64
+	Count int
+}
+
+// QueueIntervalRecord holds one (QueueID, MediaType, Interval) row of aggregated queue
+// statistics, ready to be upserted by a StatsWriter.
+type QueueIntervalRecord struct {
+	QueueID   string
+	QueueName string
+	MediaType string
+	Interval  time.Time
+
+	Counts  map[string]int    // keyed by countColumns
+	Metrics map[string]Metric // keyed by metricNames
+}
+
+// columns returns the non-key columns in a fixed order, matching the table schema.
+func columns() []string {
+	cols := append([]string{}, countColumns...)
+	for _, name := range metricNames {
+		cols = append(cols, "t"+name, "mt"+name, "n"+name)
+	}
+	return cols
+}
+
+// values returns rec's non-key column values in the same order as columns().
+func values(rec QueueIntervalRecord) []interface{} {
+	vals := make([]interface{}, 0, len(countColumns)+len(metricNames)*3)
+	for _, col := range countColumns {
+		vals = append(vals, rec.Counts[col])
+	}
+	for _, name := range metricNames {
+		m := rec.Metrics[name]
+		vals = append(vals, m.Sum, m.Max, m.Count)
+	}
+	return vals
+}
+
+// StatsWriter upserts queue interval statistics into a SQL database within a single
+// transaction shared by every call to WriteQueueInterval.
+type StatsWriter struct {
+	db     *sql.DB
+	driver Driver
+	tx     *sql.Tx
+}
+
+// NewStatsWriter returns a StatsWriter that writes to db using the SQL dialect for driver.
+func NewStatsWriter(db *sql.DB, driver Driver) *StatsWriter {
+	return &StatsWriter{db: db, driver: driver}
+}
+
+// Begin starts the transaction that every subsequent WriteQueueInterval call becomes part of.
+func (w *StatsWriter) Begin() (err error) {
+	w.tx, err = w.db.Begin()
+	return
+}
+
+// Commit commits the transaction started by Begin.
+func (w *StatsWriter) Commit() error {
+	return w.tx.Commit()
+}
+
+// Rollback rolls back the transaction started by Begin.
+func (w *StatsWriter) Rollback() error {
+	return w.tx.Rollback()
+}
+
+// WriteQueueInterval upserts a single queue interval record, keyed on (QueueID, MediaType,
+// Interval). It must be called between Begin and Commit/Rollback.
+func (w *StatsWriter) WriteQueueInterval(rec QueueIntervalRecord) error {
+	return upsert(w.tx, w.driver, row{
+		table:          Table,
+		keyCols:        []string{"QueueID", "MediaType", "Interval"},
+		keyVals:        []interface{}{rec.QueueID, rec.MediaType, rec.Interval},
+		insertOnlyCols: []string{"QueueName"},
+		insertOnlyVals: []interface{}{rec.QueueName},
+		dataCols:       columns(),
+		dataVals:       values(rec),
+	})
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SyncStateTable tracks, for each (QueueID, MediaType), the most recent interval that has
+// been successfully written to Table. Callers may also store other keys in the same columns
+// (main records agent rows of AgentTable with the UserID as QueueID). The daemon scheduler
+// uses it to work out which intervals are missing and need to be backfilled.
+const SyncStateTable = "sync_state"
+
+// LastWritten returns the most recent interval written for (queueID, mediaType). ok is false
+// if nothing has ever been written for that key.
+func LastWritten(db *sql.DB, driver Driver, queueID string, mediaType string) (interval time.Time, ok bool, err error) {
+	where := "QueueID = ? AND MediaType = ?"
+	if driver == DriverPostgres {
+		where = "QueueID = $1 AND MediaType = $2"
+	}
+	err = db.QueryRow("SELECT LastInterval FROM "+SyncStateTable+" WHERE "+where, queueID, mediaType).Scan(&interval)
+	switch {
+	case err == sql.ErrNoRows:
+		return time.Time{}, false, nil
+	case err != nil:
+		return time.Time{}, false, err
+	default:
+		return interval, true, nil
+	}
+}
+
+// RecordLastWritten upserts the most recent interval written for (queueID, mediaType). Neither
+// StatsWriter nor AgentStatsWriter updates sync_state, so callers call this once their writes
+// have been flushed or committed, so daemon mode knows what to backfill next.
+func RecordLastWritten(db *sql.DB, driver Driver, queueID string, mediaType string, interval time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = setLastWritten(tx, driver, queueID, mediaType, interval); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setLastWritten upserts the most recent interval written for (queueID, mediaType) using tx.
+func setLastWritten(tx *sql.Tx, driver Driver, queueID string, mediaType string, interval time.Time) error {
+	return upsert(tx, driver, row{
+		table:    SyncStateTable,
+		keyCols:  []string{"QueueID", "MediaType"},
+		keyVals:  []interface{}{queueID, mediaType},
+		dataCols: []string{"LastInterval"},
+		dataVals: []interface{}{interval},
+	})
+}
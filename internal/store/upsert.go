@@ -0,0 +1,134 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// row describes a single upsertable row, shared by every table this package writes to.
+// keyCols/keyVals identify the row; insertOnlyCols/insertOnlyVals are written only when the
+// row is first inserted (e.g. a friendly display name that shouldn't be clobbered on update);
+// dataCols/dataVals are written on both insert and update.
+type row struct {
+	table string
+
+	keyCols []string
+	keyVals []interface{}
+
+	insertOnlyCols []string
+	insertOnlyVals []interface{}
+
+	dataCols []string
+	dataVals []interface{}
+}
+
+// upsert inserts or updates r within tx, dispatching to the SQL dialect for driver: MERGE for
+// SQL Server, INSERT ... ON CONFLICT for PostgreSQL, and a select-then-
+// update-or-insert fallback for everything else (ODBC, MySQL, SQLite).
+func upsert(tx *sql.Tx, driver Driver, r row) error {
+	switch driver {
+	case DriverMSSQL:
+		return upsertMerge(tx, r)
+	case DriverPostgres:
+		return upsertOnConflict(tx, r)
+	default:
+		return upsertSelectThenUpsert(tx, r)
+	}
+}
+
+// upsertMerge upserts r using a single MERGE statement.
+func upsertMerge(tx *sql.Tx, r row) error {
+	allCols := append(append(append([]string{}, r.keyCols...), r.insertOnlyCols...), r.dataCols...)
+
+	setClause := make([]string, len(r.dataCols))
+	for i, col := range r.dataCols {
+		setClause[i] = fmt.Sprintf("target.%s = source.%s", col, col)
+	}
+
+	onClause := make([]string, len(r.keyCols))
+	for i, col := range r.keyCols {
+		onClause[i] = fmt.Sprintf("target.%s = source.%s", col, col)
+	}
+
+	insertValues := make([]string, len(allCols))
+	for i, col := range allCols {
+		insertValues[i] = "source." + col
+	}
+
+	sourcePlaceholders := strings.TrimRight(strings.Repeat("?, ", len(allCols)), ", ")
+
+	query := fmt.Sprintf(
+		"MERGE %s AS target USING (SELECT %s) AS source (%s) "+
+			"ON %s "+
+			"WHEN MATCHED THEN UPDATE SET %s "+
+			"WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		r.table, sourcePlaceholders, strings.Join(allCols, ", "), strings.Join(onClause, " AND "), strings.Join(setClause, ", "), strings.Join(allCols, ", "), strings.Join(insertValues, ", "))
+
+	args := append(append(append([]interface{}{}, r.keyVals...), r.insertOnlyVals...), r.dataVals...)
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+// upsertOnConflict upserts r using INSERT ... ON CONFLICT DO UPDATE, as supported by PostgreSQL.
+func upsertOnConflict(tx *sql.Tx, r row) error {
+	allCols := append(append(append([]string{}, r.keyCols...), r.insertOnlyCols...), r.dataCols...)
+
+	placeholders := make([]string, len(allCols))
+	for i := range allCols {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	setClause := make([]string, len(r.dataCols))
+	for i, col := range r.dataCols {
+		setClause[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		r.table, strings.Join(allCols, ", "), strings.Join(placeholders, ", "), strings.Join(r.keyCols, ", "), strings.Join(setClause, ", "))
+
+	args := append(append(append([]interface{}{}, r.keyVals...), r.insertOnlyVals...), r.dataVals...)
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+// upsertSelectThenUpsert is the fallback dialect for drivers without a native upsert (ODBC,
+// MySQL, SQLite): it selects for existence inside the transaction, then issues a prepared
+// UPDATE or INSERT.
+func upsertSelectThenUpsert(tx *sql.Tx, r row) error {
+	keyWhere := make([]string, len(r.keyCols))
+	for i, col := range r.keyCols {
+		keyWhere[i] = col + " = ?"
+	}
+
+	var exists bool
+	var discard string
+	err := tx.QueryRow("SELECT "+r.keyCols[0]+" FROM "+r.table+" WHERE "+strings.Join(keyWhere, " AND "), r.keyVals...).Scan(&discard)
+	switch {
+	case err == sql.ErrNoRows:
+		exists = false
+	case err != nil:
+		return err
+	default:
+		exists = true
+	}
+
+	if exists {
+		setClause := make([]string, len(r.dataCols))
+		for i, col := range r.dataCols {
+			setClause[i] = col + " = ?"
+		}
+		query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", r.table, strings.Join(setClause, ", "), strings.Join(keyWhere, " AND "))
+		args := append(append([]interface{}{}, r.dataVals...), r.keyVals...)
+		_, err = tx.Exec(query, args...)
+		return err
+	}
+
+	allCols := append(append(append([]string{}, r.keyCols...), r.insertOnlyCols...), r.dataCols...)
+	placeholders := strings.TrimRight(strings.Repeat("?, ", len(allCols)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", r.table, strings.Join(allCols, ", "), placeholders)
+	args := append(append(append([]interface{}{}, r.keyVals...), r.insertOnlyVals...), r.dataVals...)
+	_, err = tx.Exec(query, args...)
+	return err
+}
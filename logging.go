@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/szemin-ng/purecloud2odbc/sinks"
+)
+
+// runID identifies this execution in log output so a single run can be grepped out of a shared
+// log stream across a multi-tenant deployment. It is generated once at process start.
+var runID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
+// logger is the structured logger every part of the app writes through, configured from
+// AppConfig.LogLevel/LogFormat by setupLogging.
+var logger zerolog.Logger
+
+// setupLogging configures the global logger from AppConfig.LogLevel (default "info") and
+// AppConfig.LogFormat ("json", the default, or "console" for human-friendly output), and
+// stamps every log line with run_id.
+func setupLogging() {
+	level, err := zerolog.ParseLevel(appConfig.LogLevel)
+	if err != nil || level == zerolog.NoLevel {
+		level = zerolog.InfoLevel
+	}
+	zerolog.SetGlobalLevel(level)
+
+	var w = os.Stdout
+	var base zerolog.Logger
+	if appConfig.LogFormat == "console" {
+		base = zerolog.New(zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339})
+	} else {
+		base = zerolog.New(w)
+	}
+
+	logger = base.With().Timestamp().Str("run_id", runID).Logger()
+	sinks.Logger = logger
+}
@@ -2,20 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/szemin-ng/purecloud"
 	"github.com/szemin-ng/purecloud/analytics"
 	"github.com/szemin-ng/purecloud/routing"
+	"github.com/szemin-ng/purecloud/users"
+	"github.com/szemin-ng/purecloud2odbc/internal/store"
+	"github.com/szemin-ng/purecloud2odbc/sinks"
 
 	_ "github.com/alexbrainman/odbc"
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // AppConfig stores the application's config data
@@ -23,17 +34,39 @@ type AppConfig struct {
 	PureCloudRegion       string   `json:"pureCloudRegion"`
 	PureCloudClientID     string   `json:"pureCloudClientId"`
 	PureCloudClientSecret string   `json:"pureCloudClientSecret"`
-	OdbcDsn               string   `json:"odbcDsn"`
+	Driver                string   `json:"driver"` // odbc, mssql, postgres, mysql or sqlite
+	Dsn                   string   `json:"dsn"`
 	Granularity           string   `json:"granularity"`
 	Queues                []string `json:"queues"`
 	Agents                []string `json:"agents"`
+	Daemon                bool     `json:"daemon"`
+	PollInterval          string   `json:"pollInterval"`   // e.g. "1m", how often to poll PureCloud in daemon mode
+	LookbackWindow        string   `json:"lookbackWindow"` // e.g. "24h", how far back daemon mode will backfill missing intervals
+
+	LogLevel  string `json:"logLevel"`  // debug, info (default), warn or error
+	LogFormat string `json:"logFormat"` // json (default) or console
+
+	MaxRetries     int    `json:"maxRetries"`     // max attempts per PureCloud API call, including the first (default 5)
+	MaxBackoff     string `json:"maxBackoff"`     // e.g. "30s", cap on exponential backoff between retries (default 30s)
+	RequestTimeout string `json:"requestTimeout"` // e.g. "30s", timeout applied to each PureCloud API call (default 30s)
+
+	Sink                 string `json:"sink"` // odbc (default), influxdb or prometheus
+	InfluxDBURL          string `json:"influxDbUrl"`
+	InfluxDBDatabase     string `json:"influxDbDatabase"`
+	PrometheusListenAddr string `json:"prometheusListenAddr"`
 }
 
 const configFile string = ""
 
-//const configFile string = `c:\users\sze min\documents\go projects\src\purecloud2odbc\config.json`
+// const configFile string = `c:\users\sze min\documents\go projects\src\purecloud2odbc\config.json`
 const timeFormat string = "2006-01-02T15:04:05-0700"
 const queueIntervalStatsTable string = "QueueIntervalStats"
+const agentIntervalStatsTable string = "AgentIntervalStats"
+
+// agentSyncStateMediaType is the sentinel MediaType value sync_state rows use for agent
+// interval entries, distinguishing them from the real queue MediaType values (voice, chat,
+// email) that share the same table and columns.
+const agentSyncStateMediaType string = "__agent__"
 
 var appConfig AppConfig // global app config
 var supportedGranularity = map[string]time.Duration{"PT15M": time.Minute * 15, "PT30M": time.Minute * 30, "PT60M": time.Hour * 1, "PT1H": time.Hour * 1, "P1D": time.Hour * 24}
@@ -44,76 +77,195 @@ var db *sql.DB
 func main() {
 	var err error
 
+	driverFlag := flag.String("driver", "", "database driver to use: odbc, mssql, postgres, mysql or sqlite (overrides the driver in the config file)")
+	daemonFlag := flag.Bool("daemon", false, "run continuously instead of exiting after a single interval")
+	flag.Parse()
+
 	if err = loadAppConfig(configFile); err != nil {
 		fmt.Printf("Error: %s\n", err)
 		return
 	}
 
-	// Connect to ODBC database
-	if db, err = sql.Open("odbc", "DSN="+appConfig.OdbcDsn); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	setupLogging()
+
+	stopStatsCh := make(chan struct{})
+	defer close(stopStatsCh)
+	startStatsReporter(stopStatsCh)
+
+	if *driverFlag != "" {
+		appConfig.Driver = *driverFlag
+	}
+	if *daemonFlag {
+		appConfig.Daemon = true
+	}
+	if appConfig.Driver == "" {
+		appConfig.Driver = string(store.DriverODBC)
+	}
+
+	dbDriver := store.Driver(appConfig.Driver)
+	var driverName string
+	if driverName, err = store.DriverName(dbDriver); err != nil {
+		logger.Error().Err(err).Msg("unsupported driver")
+		return
+	}
+
+	// Connect to the configured database. ODBC takes a DSN name rather than a connection string.
+	var dsn = appConfig.Dsn
+	if dbDriver == store.DriverODBC {
+		dsn = "DSN=" + appConfig.Dsn
+	}
+	if db, err = sql.Open(driverName, dsn); err != nil {
+		logger.Error().Err(err).Msg("failed to open database")
 		return
 	}
 	defer db.Close()
 
 	if err = db.Ping(); err != nil {
-		fmt.Printf("Error: %s\n", err)
+		logger.Error().Err(err).Msg("failed to ping database")
 		return
 	}
 
 	// Prepare ODBC tables.  NO ERROR CHECKS (TODO)
-	prepareDbTables()
+	// prepareDbTables' DDL (WITH PRIMARY, LONG/DOUBLE column types) is Jet/ODBC-specific, so it
+	// can't be reused to provision schema for the other drivers. Operators running mssql,
+	// postgres, mysql or sqlite must create QueueIntervalStats, sync_state and
+	// AgentIntervalStats themselves; fail fast here with a clear error rather than letting the
+	// first write fail with an opaque "table does not exist" SQL error.
+	if dbDriver == store.DriverODBC {
+		prepareDbTables()
+	} else if err = verifySchemaProvisioned(); err != nil {
+		logger.Error().Err(err).Msg("database schema not provisioned")
+		return
+	}
 
-	// Login to PureCloud using Client Credentials login
-	if err = loginToPureCloud(); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	ctx := context.Background()
+
+	// Log in to PureCloud using Client Credentials login. Later calls through pureCloudClient
+	// re-log-in automatically once the token is close to expiry, so this is just to fail fast.
+	if err = pureCloudClient.ensureToken(ctx); err != nil {
+		logger.Error().Err(err).Msg("failed to log into PureCloud")
 		return
 	}
 
 	// Cache a list of queue names to match with QueueIDs
 	var queues map[string]string
-	if queues, err = getPureCloudQueues(); err != nil {
+	if queues, err = getPureCloudQueues(ctx); err != nil {
 		return
 	}
 
+	// Cache a list of agent names to match with UserIDs, if any agents are configured
+	var agents map[string]string
+	if len(appConfig.Agents) > 0 {
+		if agents, err = getPureCloudAgents(ctx); err != nil {
+			return
+		}
+	}
+
+	if appConfig.Daemon {
+		if err = runDaemon(queues, agents); err != nil {
+			logger.Error().Err(err).Msg("daemon exited with an error")
+		}
+		return
+	}
+
+	// LookbackWindow only matters to daemon mode's backfill; single-run mode falls back to a
+	// sane default if it's unset or invalid rather than requiring it to be configured.
+	retention, err := time.ParseDuration(appConfig.LookbackWindow)
+	if err != nil || retention <= 0 {
+		retention = 24 * time.Hour
+	}
+
+	var sink sinks.Sink
+	if sink, err = newSink(retention); err != nil {
+		logger.Error().Err(err).Msg("failed to create sink")
+		return
+	}
+	defer sink.Close()
+
 	// Get queue interval statistics from PureCloud
 	var resp purecloud.AggregateQueryResponse
-	if resp, err = getPureCloudQueueStats(); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	startInterval, endInterval := currentInterval()
+	if resp, err = getPureCloudQueueStats(ctx, startInterval, endInterval); err != nil {
+		logger.Error().Err(err).Msg("failed to get queue stats from PureCloud")
 		return
 	}
 
-	// Write queue interval stats to DB
-	if err = writeQueueStatsToDb(resp, queues); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	// Write queue interval stats to the configured sink
+	if err = writeQueueStats(ctx, resp, queues, sink); err != nil {
+		logger.Error().Err(err).Msg("failed to write queue stats")
 		return
 	}
+
+	// Get and write agent interval statistics, if any agents are configured. Agent stats are
+	// written straight to the database rather than through a Sink: unlike queue stats there's
+	// no requirement yet to also push them to InfluxDB/Prometheus.
+	if len(agents) > 0 {
+		var agentResp purecloud.AggregateQueryResponse
+		if agentResp, err = getPureCloudAgentStats(ctx, startInterval, endInterval); err != nil {
+			logger.Error().Err(err).Msg("failed to get agent stats from PureCloud")
+			return
+		}
+		if err = writeAgentStats(agentResp, agents); err != nil {
+			logger.Error().Err(err).Msg("failed to write agent stats")
+			return
+		}
+	}
 }
 
 // getPureCloudQueues returns a map of queueIDs and its corresponding queue names. Up to 1,000 active and inactive queues are returned.
-func getPureCloudQueues() (queues map[string]string, err error) {
+func getPureCloudQueues(ctx context.Context) (queues map[string]string, err error) {
 	var p = routing.GetQueueParams{PageSize: 1000, PageNumber: 1, Active: false}
 	var queueList routing.QueueEntityListing
 
 	queues = make(map[string]string)
 
-	fmt.Printf("Retrieving list of configured queues...\n")
-	if queueList, err = routing.GetListOfQueues(pureCloudToken, p); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	logger.Info().Msg("retrieving list of configured queues")
+	if err = pureCloudClient.do(ctx, func() error {
+		var err error
+		queueList, err = routing.GetListOfQueues(pureCloudToken, p)
+		return err
+	}); err != nil {
+		logger.Error().Err(err).Msg("failed to get list of queues")
 		return
 	}
 
 	for _, queue := range queueList.Entities {
 		queues[queue.ID] = queue.Name
 	}
-	fmt.Printf("Mapped %d queues\n", len(queues))
+	logger.Info().Int("count", len(queues)).Msg("mapped queues")
 
 	return
 }
 
-func getPureCloudQueueStats() (resp purecloud.AggregateQueryResponse, err error) {
-	// Format interval parameter for PureCloud's API call
-	var startInterval, endInterval time.Time
+// getPureCloudAgents returns a map of UserIDs and their corresponding agent names. Up to 1,000
+// users are returned; the map is not restricted to the agents configured in AppConfig.Agents.
+func getPureCloudAgents(ctx context.Context) (agents map[string]string, err error) {
+	var p = users.GetUsersParams{PageSize: 1000, PageNumber: 1}
+	var userList users.UserEntityListing
+
+	agents = make(map[string]string)
+
+	logger.Info().Msg("retrieving list of configured agents")
+	if err = pureCloudClient.do(ctx, func() error {
+		var err error
+		userList, err = users.GetListOfUsers(pureCloudToken, p)
+		return err
+	}); err != nil {
+		logger.Error().Err(err).Msg("failed to get list of users")
+		return
+	}
+
+	for _, user := range userList.Entities {
+		agents[user.ID] = user.Name
+	}
+	logger.Info().Int("count", len(agents)).Msg("mapped agents")
+
+	return
+}
+
+// currentInterval returns the [start, end) bounds of the interval that is currently in
+// progress for the configured granularity.
+func currentInterval() (startInterval, endInterval time.Time) {
 	var y, d int
 	var m time.Month
 	var l *time.Location
@@ -127,7 +279,14 @@ func getPureCloudQueueStats() (resp purecloud.AggregateQueryResponse, err error)
 		startInterval = time.Now().Truncate(supportedGranularity[appConfig.Granularity])
 		endInterval = startInterval.Add(supportedGranularity[appConfig.Granularity])
 	}
+	return
+}
 
+// getPureCloudQueueStats queries queue interval statistics for [startInterval, endInterval).
+// PureCloud buckets the range into one entry per configured granularity, so callers wanting
+// several intervals' worth of data (e.g. a daemon backfilling missed intervals) can pass a
+// wider range in a single call.
+func getPureCloudQueueStats(ctx context.Context, startInterval, endInterval time.Time) (resp purecloud.AggregateQueryResponse, err error) {
 	// Create the following query to use in API call
 	/*{
 	   "interval": "2016-06-08T00:00:00+08:00/2016-06-09T00:00:00+08:00",
@@ -161,7 +320,7 @@ func getPureCloudQueueStats() (resp purecloud.AggregateQueryResponse, err error)
 		Filter: &purecloud.AnalyticsQueryFilter{
 			Type: "and",
 		},
-		GroupBy: []string{"mediaType", "queueId"},
+		GroupBy:                      []string{"mediaType", "queueId"},
 		FlattenMultiValuedDimensions: true,
 	}
 
@@ -181,14 +340,218 @@ func getPureCloudQueueStats() (resp purecloud.AggregateQueryResponse, err error)
 	query.Filter.Clauses = append(query.Filter.Clauses, mediaTypeClause)
 	query.Filter.Clauses = append(query.Filter.Clauses, queueIDClause)
 
-	if resp, err = analytics.QueryConversationAggregates(pureCloudToken, query); err != nil {
-		fmt.Printf("Error: %s\n", err)
+	if err = pureCloudClient.do(ctx, func() error {
+		var err error
+		resp, err = analytics.QueryConversationAggregates(pureCloudToken, query)
+		return err
+	}); err != nil {
+		logger.Error().Err(err).Msg("failed to query conversation aggregates")
+		return
+	}
+
+	return
+}
+
+// getPureCloudAgentStats queries per-agent interval statistics for [startInterval, endInterval),
+// restricted to the users configured in AppConfig.Agents. It mirrors getPureCloudQueueStats,
+// grouping by userId instead of queueId/mediaType.
+func getPureCloudAgentStats(ctx context.Context, startInterval, endInterval time.Time) (resp purecloud.AggregateQueryResponse, err error) {
+	var query = purecloud.AggregationQuery{
+		Interval:    startInterval.Format(timeFormat) + "/" + endInterval.Format(timeFormat),
+		Granularity: appConfig.Granularity,
+		Filter: &purecloud.AnalyticsQueryFilter{
+			Type: "and",
+		},
+		GroupBy:                      []string{"userId"},
+		FlattenMultiValuedDimensions: true,
+	}
+
+	// Add user ID clause into the query
+	var userIDClause = purecloud.AnalyticsQueryClause{Type: "or"}
+	for _, userID := range appConfig.Agents {
+		userIDClause.Predicates = append(userIDClause.Predicates, purecloud.AnalyticsQueryPredicate{Dimension: "userId", Value: userID})
+	}
+	query.Filter.Clauses = append(query.Filter.Clauses, userIDClause)
+
+	if err = pureCloudClient.do(ctx, func() error {
+		var err error
+		resp, err = analytics.QueryUserAggregates(pureCloudToken, query)
+		return err
+	}); err != nil {
+		logger.Error().Err(err).Msg("failed to query user aggregates")
 		return
 	}
 
 	return
 }
 
+// runDaemon polls PureCloud on a PollInterval timer until SIGINT or SIGTERM, backfilling any
+// interval that has not yet been written (bounded by LookbackWindow) and re-fetching the
+// previous interval on every tick so late-arriving PureCloud metrics get picked up. It
+// returns once a shutdown signal has been handled.
+func runDaemon(queues map[string]string, agents map[string]string) error {
+	pollInterval, err := time.ParseDuration(appConfig.PollInterval)
+	if err != nil {
+		return fmt.Errorf("invalid pollInterval: %s", err)
+	}
+	lookbackWindow, err := time.ParseDuration(appConfig.LookbackWindow)
+	if err != nil {
+		return fmt.Errorf("invalid lookbackWindow: %s", err)
+	}
+	if lookbackWindow <= 0 {
+		return fmt.Errorf("invalid lookbackWindow: must be positive, got %s", lookbackWindow)
+	}
+
+	var sink sinks.Sink
+	if sink, err = newSink(lookbackWindow); err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// Handle the shutdown signal on its own goroutine so it cancels ctx even while a poll is
+	// in progress.
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logger.Info().Str("signal", sig.String()).Msg("received shutdown signal")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	logger.Info().Dur("poll_interval", pollInterval).Msg("running in daemon mode")
+	if err = poll(ctx, queues, agents, sink, lookbackWindow); err != nil {
+		logger.Error().Err(err).Msg("poll failed")
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err = poll(ctx, queues, agents, sink, lookbackWindow); err != nil {
+				logger.Error().Err(err).Msg("poll failed")
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll fetches and writes every interval that has not yet been written for queues, bounded by
+// lookbackWindow, then does the same for agents if any are configured. ctx is cancelled by
+// runDaemon on shutdown, so a poll already waiting out a retry backoff stops rather than
+// delaying the shutdown.
+func poll(ctx context.Context, queues map[string]string, agents map[string]string, sink sinks.Sink, lookbackWindow time.Duration) error {
+	_, endInterval := currentInterval()
+	granularity := supportedGranularity[appConfig.Granularity]
+
+	startInterval, err := earliestMissingInterval(queues, endInterval, lookbackWindow, granularity)
+	if err != nil {
+		return err
+	}
+
+	var resp purecloud.AggregateQueryResponse
+	if resp, err = getPureCloudQueueStats(ctx, startInterval, endInterval); err != nil {
+		return err
+	}
+
+	if err = writeQueueStats(ctx, resp, queues, sink); err != nil {
+		return err
+	}
+
+	if len(agents) == 0 {
+		return nil
+	}
+
+	agentStartInterval, err := earliestMissingAgentInterval(agents, endInterval, lookbackWindow, granularity)
+	if err != nil {
+		return err
+	}
+
+	var agentResp purecloud.AggregateQueryResponse
+	if agentResp, err = getPureCloudAgentStats(ctx, agentStartInterval, endInterval); err != nil {
+		return err
+	}
+
+	return writeAgentStats(agentResp, agents)
+}
+
+// earliestMissingInterval returns the earliest interval that needs to be (re-)fetched: one
+// granularity before the oldest last-written interval across the configured queues and media
+// types, so that the previous interval is re-written too, or now-lookbackWindow if nothing has
+// been written yet.
+func earliestMissingInterval(queues map[string]string, now time.Time, lookbackWindow time.Duration, granularity time.Duration) (time.Time, error) {
+	floor := now.Add(-lookbackWindow)
+	start := floor
+	found := false
+
+	for queueID := range queues {
+		for _, mediaType := range supportedMediaType {
+			lastWritten, ok, err := store.LastWritten(db, store.Driver(appConfig.Driver), queueID, mediaType)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if !ok {
+				continue
+			}
+			if !found || lastWritten.Before(start) {
+				start = lastWritten
+				found = true
+			}
+		}
+	}
+
+	if found {
+		start = start.Add(-granularity)
+		if start.Before(floor) {
+			start = floor
+		}
+	}
+
+	return start, nil
+}
+
+// earliestMissingAgentInterval is earliestMissingInterval's counterpart for agent interval
+// stats: it looks up the last written interval per UserID, reusing sync_state's (QueueID,
+// MediaType) columns with the UserID stored in QueueID and agentSyncStateMediaType in
+// MediaType.
+func earliestMissingAgentInterval(agents map[string]string, now time.Time, lookbackWindow time.Duration, granularity time.Duration) (time.Time, error) {
+	floor := now.Add(-lookbackWindow)
+	start := floor
+	found := false
+
+	for userID := range agents {
+		lastWritten, ok, err := store.LastWritten(db, store.Driver(appConfig.Driver), userID, agentSyncStateMediaType)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !ok {
+			continue
+		}
+		if !found || lastWritten.Before(start) {
+			start = lastWritten
+			found = true
+		}
+	}
+
+	if found {
+		start = start.Add(-granularity)
+		if start.Before(floor) {
+			start = floor
+		}
+	}
+
+	return start, nil
+}
+
 // loadAppConfig loads the config file for the app to run. If a configFile is passed in, e.g., C:\config.json, it uses that file. This is for testing purposes.
 // In production, null string should be passed in so that it looks for the config file at os.Args[1]
 func loadAppConfig(configFile string) (err error) {
@@ -226,17 +589,6 @@ func loadAppConfig(configFile string) (err error) {
 	return
 }
 
-// loginToPureCloud logs into PureCloud using client credentials login
-func loginToPureCloud() (err error) {
-	fmt.Printf("Logging into PureCloud...\r")
-	if pureCloudToken, err = purecloud.LoginWithClientCredentials(appConfig.PureCloudRegion, appConfig.PureCloudClientID, appConfig.PureCloudClientSecret); err != nil {
-		fmt.Printf("Error: %s\n", err)
-		return
-	}
-	fmt.Printf("Successfully logged in.\n")
-	return
-}
-
 // prepareDbTables creates the table to hold queue interval stats. It does not do any error checks, just prints
 // out any errors it receives
 func prepareDbTables() {
@@ -268,179 +620,209 @@ func prepareDbTables() {
 		"tTalk DOUBLE, mtTalk DOUBLE, nTalk LONG, " +
 		"tTalkComplete DOUBLE, mtTalkComplete DOUBLE, nTalkComplete LONG, " +
 		"tUserResponseTime DOUBLE, mtUserResponseTime DOUBLE, nUserResponseTime LONG)"); err != nil {
-		fmt.Println(err)
+		logger.Warn().Err(err).Msg("create table failed")
 	}
 
 	// Create index, if error, just print it out and continue
 	if _, err = db.Exec("CREATE INDEX QueueIndex ON " + queueIntervalStatsTable + " (QueueId, MediaType, Interval) WITH PRIMARY"); err != nil {
-		fmt.Println(err)
+		logger.Warn().Err(err).Msg("create index failed")
+	}
+
+	// Create table used by daemon mode to track the last interval written per queue/media
+	// type, if error, just print it out and continue
+	if _, err = db.Exec("CREATE TABLE " + store.SyncStateTable + " (QueueID VARCHAR, MediaType VARCHAR, LastInterval DATETIME)"); err != nil {
+		logger.Warn().Err(err).Msg("create table failed")
+	}
+	if _, err = db.Exec("CREATE INDEX SyncStateIndex ON " + store.SyncStateTable + " (QueueId, MediaType) WITH PRIMARY"); err != nil {
+		logger.Warn().Err(err).Msg("create index failed")
+	}
+
+	// Create table to hold agent interval stats, if error, just print it out and continue
+	if _, err = db.Exec("CREATE TABLE " + agentIntervalStatsTable + " (UserID VARCHAR, UserName VARCHAR, Interval DATETIME, " +
+		"tAgentRoutingStatus DOUBLE, mtAgentRoutingStatus DOUBLE, nAgentRoutingStatus LONG, " +
+		"tOrganizationPresence DOUBLE, mtOrganizationPresence DOUBLE, nOrganizationPresence LONG, " +
+		"tHandle DOUBLE, mtHandle DOUBLE, nHandle LONG, " +
+		"tTalk DOUBLE, mtTalk DOUBLE, nTalk LONG, " +
+		"tAcw DOUBLE, mtAcw DOUBLE, nAcw LONG, " +
+		"tHold DOUBLE, mtHold DOUBLE, nHold LONG)"); err != nil {
+		logger.Warn().Err(err).Msg("create table failed")
+	}
+
+	// Create index, if error, just print it out and continue
+	if _, err = db.Exec("CREATE INDEX AgentIndex ON " + agentIntervalStatsTable + " (UserID, Interval) WITH PRIMARY"); err != nil {
+		logger.Warn().Err(err).Msg("create index failed")
 	}
 }
 
-// queueIntervalExists checks if a queue interval exists in the database table, the primary key is a combination
-// of QueueID, MediaType and Interval
-func queueIntervalExists(queueID string, mediaType string, interval time.Time) (exists bool, err error) {
-	var data string
-	err = db.QueryRow("SELECT QueueID FROM "+queueIntervalStatsTable+" WHERE QueueID = ? AND MediaType = ? AND Interval = ?", queueID, mediaType, interval).Scan(&data)
-	switch {
-	case err == sql.ErrNoRows: // queue interval don't exist
-		exists = false
-		err = nil
-		return
-	case err != nil: // some other error
-		return
+// verifySchemaProvisioned checks that QueueIntervalStats, sync_state and AgentIntervalStats
+// already exist, for the drivers prepareDbTables can't create schema for (see main). Returns a
+// clear error naming the missing table instead of leaving the operator to debug an opaque SQL
+// error on the first write.
+func verifySchemaProvisioned() error {
+	for _, table := range []string{queueIntervalStatsTable, store.SyncStateTable, agentIntervalStatsTable} {
+		rows, err := db.Query("SELECT 1 FROM " + table + " WHERE 1 = 0")
+		if err != nil {
+			return fmt.Errorf("table %s is missing or inaccessible; schema must be pre-provisioned for driver %q: %w", table, appConfig.Driver, err)
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// newSink builds the Sink selected by AppConfig.Sink ("odbc" if unset). retention is passed
+// through to PrometheusSink to bound its delta-tracking state; callers pass the same
+// lookbackWindow their caller validated, rather than newSink re-parsing it itself.
+func newSink(retention time.Duration) (sinks.Sink, error) {
+	switch appConfig.Sink {
+	case "", "odbc":
+		return sinks.NewODBCSink(db, store.Driver(appConfig.Driver)), nil
+	case "influxdb":
+		return sinks.NewInfluxDBSink(sinks.InfluxDBConfig{URL: appConfig.InfluxDBURL, Database: appConfig.InfluxDBDatabase, BatchSize: 500}), nil
+	case "prometheus":
+		return sinks.NewPrometheusSink(appConfig.PrometheusListenAddr, retention), nil
 	default:
-		exists = true // queue interval exists
-		err = nil
-		return
+		return nil, fmt.Errorf("unsupported sink %q", appConfig.Sink)
 	}
 }
 
-// writeQueueStatsToDb writes queue interval statistics in the response from /api/v2/analytics/conversations/aggregates/query into a database.
-func writeQueueStatsToDb(dataset purecloud.AggregateQueryResponse, queueMap map[string]string) (err error) {
+// writeQueueStats pushes the response from /api/v2/analytics/conversations/aggregates/query
+// through sink, then records the newest interval written per (queueID, mediaType) into
+// sync_state so daemon mode knows what to backfill next.
+func writeQueueStats(ctx context.Context, dataset purecloud.AggregateQueryResponse, queueMap map[string]string, sink sinks.Sink) (err error) {
 	var i int
+	intervalsSeen := make(map[string]bool)
+	newest := make(map[[2]string]time.Time)
 
 	// Loop through results[]
 	for _, result := range dataset.Results {
-		var queueID, queueName, mediaType string // declare here so that it gets initialize for every iteration
-
-		// Map queueID to a friendly queue name, replacing single quotes with '' for SQL statement compatibility. If name is not found, use queueID
-		queueID = result.Group.QueueID
-		queueName = strings.Replace(queueMap[queueID], "'", "''", -1)
+		// Map queueID to a friendly queue name. If name is not found, use queueID
+		queueID := result.Group.QueueID
+		queueName := queueMap[queueID]
 		if queueName == "" {
 			queueName = queueID
 		}
-
-		mediaType = result.Group.MediaType
+		mediaType := result.Group.MediaType
 
 		// Loop through results[].data[]
 		for _, data := range result.Data {
-			// declare variables here so that it gets initialized for every interval, each loop
-			var interval time.Time
-			var nError, nOffered, nOutboundAbandoned, nOutboundAttempted, nOutboundConnected, nTransferred, nOverSLA int
-			var nAbandon, nAcd, nAcw, nAgentResponseTime, nAnswered, nHandle, nHeld, nHeldComplete, nIvr, nTalk, nTalkComplete, nUserResponseTime int
-			var tAbandon, mtAbandon, tAcd, mtAcd, tAcw, mtAcw, tAgentResponseTime, mtAgentResponseTime, tAnswered, mtAnswered, tHandle, mtHandle float64
-			var tHeld, mtHeld, tHeldComplete, mtHeldComplete, tIvr, mtIvr, tTalk, mtTalk, tTalkComplete, mtTalkComplete, tUserResponseTime, mtUserResponseTime float64
+			rec := store.QueueIntervalRecord{
+				QueueID:   queueID,
+				QueueName: queueName,
+				MediaType: mediaType,
+				Counts:    make(map[string]int),
+				Metrics:   make(map[string]store.Metric),
+			}
 
 			var s []string
 			s = strings.Split(data.Interval, "/")
-			if interval, err = time.Parse(time.RFC3339, s[0]); err != nil {
+			if rec.Interval, err = time.Parse(time.RFC3339, s[0]); err != nil {
 				panic(fmt.Sprintf("Could not parse interval %s to RFC3339 format", s[0]))
 			}
 
 			for _, metric := range data.Metrics {
-				switch {
-				case metric.Metric == "nError":
-					nError = int(metric.Stats.Count)
-				case metric.Metric == "nOffered":
-					nOffered = int(metric.Stats.Count)
-				case metric.Metric == "nOutboundAbandoned":
-					nOutboundAbandoned = int(metric.Stats.Count)
-				case metric.Metric == "nOutboundAttempted":
-					nOutboundAttempted = int(metric.Stats.Count)
-				case metric.Metric == "nOutboundConnected":
-					nOutboundConnected = int(metric.Stats.Count)
-				case metric.Metric == "nTransferred":
-					nTransferred = int(metric.Stats.Count)
-				case metric.Metric == "nOverSla":
-					nOverSLA = int(metric.Stats.Count)
-				case metric.Metric == "tAbandon":
-					tAbandon = metric.Stats.Sum
-					mtAbandon = metric.Stats.Max
-					nAbandon = int(metric.Stats.Count)
-				case metric.Metric == "tAcd":
-					tAcd = metric.Stats.Sum
-					mtAcd = metric.Stats.Max
-					nAcd = int(metric.Stats.Count)
-				case metric.Metric == "tAcw":
-					tAcw = metric.Stats.Sum
-					mtAcw = metric.Stats.Max
-					nAcw = int(metric.Stats.Count)
-				case metric.Metric == "tAgentResponseTime":
-					tAgentResponseTime = metric.Stats.Sum
-					mtAgentResponseTime = metric.Stats.Max
-					nAgentResponseTime = int(metric.Stats.Count)
-				case metric.Metric == "tAnswered":
-					tAnswered = metric.Stats.Sum
-					mtAnswered = metric.Stats.Max
-					nAnswered = int(metric.Stats.Count)
-				case metric.Metric == "tHandle":
-					tHandle = metric.Stats.Sum
-					mtHandle = metric.Stats.Max
-					nHandle = int(metric.Stats.Count)
-				case metric.Metric == "tHeld":
-					tHeld = metric.Stats.Sum
-					mtHeld = metric.Stats.Max
-					nHeld = int(metric.Stats.Count)
-				case metric.Metric == "tHeldComplete":
-					tHeldComplete = metric.Stats.Sum
-					mtHeldComplete = metric.Stats.Max
-					nHeldComplete = int(metric.Stats.Count)
-				case metric.Metric == "tIvr":
-					tIvr = metric.Stats.Sum
-					mtIvr = metric.Stats.Max
-					nIvr = int(metric.Stats.Count)
-				case metric.Metric == "tTalk":
-					tTalk = metric.Stats.Sum
-					mtTalk = metric.Stats.Max
-					nTalk = int(metric.Stats.Count)
-				case metric.Metric == "tTalkComplete":
-					tTalkComplete = metric.Stats.Sum
-					mtTalkComplete = metric.Stats.Max
-					nTalkComplete = int(metric.Stats.Count)
-				case metric.Metric == "tUserResponseTime":
-					tUserResponseTime = metric.Stats.Sum
-					mtUserResponseTime = metric.Stats.Max
-					nUserResponseTime = int(metric.Stats.Count)
-				default:
-					panic(fmt.Sprintf("Unrecognized metric %s", metric.Metric))
+				if strings.HasPrefix(metric.Metric, "t") {
+					rec.Metrics[strings.TrimPrefix(metric.Metric, "t")] = store.Metric{Sum: metric.Stats.Sum, Max: metric.Stats.Max, Count: int(metric.Stats.Count)}
+				} else {
+					rec.Counts[metric.Metric] = int(metric.Stats.Count)
 				}
 			}
 
-			// If queue interval exists in table, update the existing interval, we don't want to violate the primary key
-			// If queue interval don't exist in table, insert a new interval
-			var exists bool
-			var t string
-			if exists, err = queueIntervalExists(queueID, mediaType, interval); err != nil {
+			if err = sink.WriteQueueInterval(ctx, rec); err != nil {
 				return
 			}
-			if exists == true {
-				fmt.Printf("Updating record %d\r", i+1)
-				t = fmt.Sprintf("UPDATE "+queueIntervalStatsTable+" SET "+
-					"nError = %d, nOffered = %d, nOutboundAbandoned = %d, nOutboundAttempted = %d, nOutboundConnected = %d, nTransferred = %d, nOverSla = %d, "+
-					"tAbandon = %f, mtAbandon = %f, nAbandon = %d, tAcd = %f, mtAcd = %f, nAcd = %d, tAcw = %f, mtAcw = %f, nAcw = %d, tAgentResponseTime = %f, mtAgentResponseTime = %f, nAgentResponseTime = %d, "+
-					"tAnswered = %f, mtAnswered = %f, nAnswered = %d, tHandle = %f, mtHandle = %f, nHandle = %d, tHeld = %f, mtHeld = %f, nHeld = %d, tHeldComplete = %f, mtHeldComplete = %f, nHeldComplete = %d, "+
-					"tIvr = %f, mtIvr = %f, nIvr = %d, tTalk = %f, mtTalk = %f, nTalk = %d, tTalkComplete = %f, mtTalkComplete = %f, nTalkComplete = %d, tUserResponseTime = %f, mtUserResponseTime = %f, nUserResponseTime = %d "+
-					"WHERE QueueId = '%s' AND MediaType = '%s' AND Interval = {ts '%s'}",
-					nError, nOffered, nOutboundAbandoned, nOutboundAttempted, nOutboundConnected, nTransferred, nOverSLA,
-					tAbandon, mtAbandon, nAbandon, tAcd, mtAcd, nAcd, tAcw, mtAcw, nAcw, tAgentResponseTime, mtAgentResponseTime, nAgentResponseTime,
-					tAnswered, mtAnswered, nAnswered, tHandle, mtHandle, nHandle, tHeld, mtHeld, nHeld, tHeldComplete, mtHeldComplete, nHeldComplete,
-					tIvr, mtIvr, nIvr, tTalk, mtTalk, nTalk, tTalkComplete, mtTalkComplete, nTalkComplete, tUserResponseTime, mtUserResponseTime, nUserResponseTime,
-					queueID, mediaType, interval.Format("2006-01-02 15:04:05"))
-			} else {
-				fmt.Printf("Inserting record %d\r", i+1)
-				t = fmt.Sprintf("INSERT INTO "+queueIntervalStatsTable+" ("+
-					"QueueID, QueueName, MediaType, Interval, nError, nOffered, nOutboundAbandoned, nOutboundAttempted, nOutboundConnected, nTransferred, nOverSla, "+
-					"tAbandon, mtAbandon, nAbandon, tAcd, mtAcd, nAcd, tAcw, mtAcw, nAcw, tAgentResponseTime, mtAgentResponseTime, nAgentResponseTime, "+
-					"tAnswered, mtAnswered, nAnswered, tHandle, mtHandle, nHandle, tHeld, mtHeld, nHeld, tHeldComplete, mtHeldComplete, nHeldComplete, "+
-					"tIvr, mtIvr, nIvr, tTalk, mtTalk, nTalk, tTalkComplete, mtTalkComplete, nTalkComplete, tUserResponseTime, mtUserResponseTime, nUserResponseTime) "+
-					"VALUES ('%s', '%s', '%s', {ts '%s'}, %d, %d, %d, %d, %d, %d, %d, "+
-					"%f, %f, %d, %f, %f, %d, %f, %f, %d, %f, %f, %d, "+
-					"%f, %f, %d, %f, %f, %d, %f, %f, %d, %f, %f, %d, "+
-					"%f, %f, %d, %f, %f, %d, %f, %f, %d, %f, %f, %d)",
-					queueID, queueName, mediaType, interval.Format("2006-01-02 15:04:05"), nError, nOffered, nOutboundAbandoned, nOutboundAttempted, nOutboundConnected, nTransferred, nOverSLA,
-					tAbandon, mtAbandon, nAbandon, tAcd, mtAcd, nAcd, tAcw, mtAcw, nAcw, tAgentResponseTime, mtAgentResponseTime, nAgentResponseTime,
-					tAnswered, mtAnswered, nAnswered, tHandle, mtHandle, nHandle, tHeld, mtHeld, nHeld, tHeldComplete, mtHeldComplete, nHeldComplete,
-					tIvr, mtIvr, nIvr, tTalk, mtTalk, nTalk, tTalkComplete, mtTalkComplete, nTalkComplete, tUserResponseTime, mtUserResponseTime, nUserResponseTime)
+			stats.addRowsWritten(1)
+			intervalsSeen[data.Interval] = true
+
+			key := [2]string{queueID, mediaType}
+			if rec.Interval.After(newest[key]) {
+				newest[key] = rec.Interval
+			}
+
+			i++
+		}
+	}
+	stats.addIntervalsProcessed(int64(len(intervalsSeen)))
+
+	if err = sink.Flush(ctx); err != nil {
+		return
+	}
+
+	for key, interval := range newest {
+		if err = store.RecordLastWritten(db, store.Driver(appConfig.Driver), key[0], key[1], interval); err != nil {
+			return
+		}
+	}
+
+	logger.Info().Int("rows_written", i).Msg("wrote queue stats")
+	return
+}
+
+// writeAgentStats writes the response from /api/v2/analytics/users/aggregates/query into
+// agentIntervalStatsTable, then records the newest interval written per UserID into sync_state
+// so daemon mode knows what to backfill next. Unlike writeQueueStats it writes straight to the
+// database rather than through a Sink, since agent stats aren't yet expected to flow to
+// InfluxDB/Prometheus.
+func writeAgentStats(dataset purecloud.AggregateQueryResponse, userMap map[string]string) (err error) {
+	writer := store.NewAgentStatsWriter(db, store.Driver(appConfig.Driver))
+	if err = writer.Begin(); err != nil {
+		return
+	}
+
+	var i int
+	intervalsSeen := make(map[string]bool)
+	newest := make(map[string]time.Time)
+
+	for _, result := range dataset.Results {
+		userID := result.Group.UserID
+		userName := userMap[userID]
+		if userName == "" {
+			userName = userID
+		}
+
+		for _, data := range result.Data {
+			rec := store.AgentIntervalRecord{
+				UserID:   userID,
+				UserName: userName,
+				Metrics:  make(map[string]store.Metric),
+			}
+
+			var s []string
+			s = strings.Split(data.Interval, "/")
+			if rec.Interval, err = time.Parse(time.RFC3339, s[0]); err != nil {
+				writer.Rollback()
+				return fmt.Errorf("could not parse interval %s to RFC3339 format: %w", s[0], err)
+			}
+
+			for _, metric := range data.Metrics {
+				rec.Metrics[strings.TrimPrefix(metric.Metric, "t")] = store.Metric{Sum: metric.Stats.Sum, Max: metric.Stats.Max, Count: int(metric.Stats.Count)}
 			}
 
-			if _, err = db.Exec(t); err != nil {
+			if err = writer.WriteAgentInterval(rec); err != nil {
+				writer.Rollback()
 				return
 			}
+			stats.addRowsWritten(1)
+			intervalsSeen[data.Interval] = true
+
+			if rec.Interval.After(newest[userID]) {
+				newest[userID] = rec.Interval
+			}
 
 			i++
 		}
 	}
-	fmt.Printf("\nWriting done\n")
+	stats.addIntervalsProcessed(int64(len(intervalsSeen)))
+
+	if err = writer.Commit(); err != nil {
+		return
+	}
+
+	for userID, interval := range newest {
+		if err = store.RecordLastWritten(db, store.Driver(appConfig.Driver), userID, agentSyncStateMediaType, interval); err != nil {
+			return
+		}
+	}
+
+	logger.Info().Int("rows_written", i).Msg("wrote agent stats")
 	return
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/szemin-ng/purecloud"
+)
+
+// tokenExpiryMargin is how long before a token's reported expiry pcClient proactively
+// re-logs-in, so a call never races a token that expires mid-request.
+const tokenExpiryMargin = 60 * time.Second
+
+// pcClient wraps the PureCloud API calls used by this app with automatic token refresh,
+// retry with exponential backoff and jitter, and Retry-After handling for 429 responses.
+//
+// The purecloud/routing/analytics/users packages don't accept a context.Context themselves,
+// so a call already in flight over the wire can't be aborted; ctx is instead checked before
+// each attempt starts and during the sleep between retries, so a cancelled context stops a
+// daemon shutdown from waiting out a long backoff or starting a new attempt.
+type pcClient struct {
+	tokenObtainedAt time.Time
+
+	// prevDone, once set, receives the error from the most recently started attempt's
+	// goroutine. fn typically writes into variables the caller's closure captured (e.g. a
+	// named return, or pureCloudToken/tokenObtainedAt below), and since a timed-out attempt's
+	// goroutine keeps running rather than being cancelled, call waits on prevDone before
+	// starting the next attempt's goroutine so two attempts never write those variables at
+	// once. This relies on every PureCloud call being driven from a single goroutine (main,
+	// or the daemon's poll loop) so that call itself is never invoked concurrently.
+	prevDone <-chan error
+}
+
+// pureCloudClient is the single pcClient shared by every PureCloud call this app makes.
+var pureCloudClient = &pcClient{}
+
+// ensureToken logs into PureCloud if no token has been obtained yet, or re-logs-in if the
+// current token expires within tokenExpiryMargin.
+func (c *pcClient) ensureToken(ctx context.Context) error {
+	if !c.tokenObtainedAt.IsZero() {
+		expiresAt := c.tokenObtainedAt.Add(time.Duration(pureCloudToken.ExpiresIn) * time.Second)
+		if time.Until(expiresAt) > tokenExpiryMargin {
+			return nil
+		}
+	}
+
+	return c.call(ctx, func() error {
+		var err error
+		logger.Info().Msg("logging into PureCloud")
+		if pureCloudToken, err = purecloud.LoginWithClientCredentials(appConfig.PureCloudRegion, appConfig.PureCloudClientID, appConfig.PureCloudClientSecret); err != nil {
+			return err
+		}
+		c.tokenObtainedAt = time.Now()
+		logger.Info().Msg("successfully logged into PureCloud")
+		return nil
+	})
+}
+
+// do ensures the token is fresh, then runs fn through call, retrying on transient failures.
+func (c *pcClient) do(ctx context.Context, fn func() error) error {
+	if err := c.ensureToken(ctx); err != nil {
+		return err
+	}
+	return c.call(ctx, fn)
+}
+
+// call runs fn, retrying on retryable errors with exponential backoff and jitter, up to
+// AppConfig.MaxRetries attempts, honoring any Retry-After duration reported by a 429 response.
+func (c *pcClient) call(ctx context.Context, fn func() error) error {
+	maxRetries := appConfig.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 5
+	}
+	maxBackoff, err := time.ParseDuration(appConfig.MaxBackoff)
+	if err != nil || maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	requestTimeout, err := time.ParseDuration(appConfig.RequestTimeout)
+	if err != nil || requestTimeout <= 0 {
+		requestTimeout = 30 * time.Second
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if c.prevDone != nil {
+			select {
+			case <-c.prevDone:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		stats.addAPICallsMade(1)
+		done := make(chan error, 1)
+		// done is closed after the result is sent, so once call has read the result, later
+		// waits on prevDone return at once instead of blocking for a value that never comes.
+		go func() { done <- fn(); close(done) }()
+		c.prevDone = done
+
+		lastErr = waitWithTimeout(done, requestTimeout)
+		if lastErr == nil {
+			return nil
+		}
+		if !isRetryable(lastErr) {
+			return lastErr
+		}
+		if attempt == maxRetries-1 {
+			break
+		}
+
+		wait := backoffWithJitter(attempt, maxBackoff)
+		if retryAfter, ok := retryAfterDuration(lastErr); ok {
+			wait = retryAfter
+		}
+
+		logger.Warn().Err(lastErr).Int("attempt", attempt+1).Dur("wait", wait).Msg("retrying PureCloud call")
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", maxRetries, lastErr)
+}
+
+// waitWithTimeout waits for done to receive the running attempt's result, or returns a timeout
+// error if it hasn't arrived within timeout. If it times out, done is left unread: the caller
+// keeps it as pcClient.prevDone and waits on it before starting the next attempt, so the
+// abandoned goroutine is known to have finished before its captured variables are touched again.
+func waitWithTimeout(done <-chan error, timeout time.Duration) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("PureCloud call timed out after %s", timeout)
+	}
+}
+
+// backoffWithJitter returns 2^attempt seconds of exponential backoff, capped at maxBackoff and
+// jittered so that several instances hitting the same rate limit don't retry in lockstep.
+func backoffWithJitter(attempt int, maxBackoff time.Duration) time.Duration {
+	backoff := time.Duration(1<<uint(attempt)) * time.Second
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+// isRetryable reports whether err looks like a transient failure worth retrying: a network
+// error, a timeout from waitWithTimeout, or a 429, 500, 502, 503 or 504 response from the
+// PureCloud API. Other 5xx codes are not retried.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "timed out") {
+		return true
+	}
+	for _, code := range []string{"429", "500", "502", "503", "504"} {
+		if strings.Contains(msg, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfterDuration extracts a Retry-After duration from err's message, if the PureCloud
+// client surfaced one (e.g. "429 Too Many Requests, Retry-After: 30").
+func retryAfterDuration(err error) (time.Duration, bool) {
+	const marker = "Retry-After:"
+
+	msg := err.Error()
+	idx := strings.Index(msg, marker)
+	if idx == -1 {
+		return 0, false
+	}
+
+	var seconds int
+	if _, scanErr := fmt.Sscanf(strings.TrimSpace(msg[idx+len(marker):]), "%d", &seconds); scanErr != nil {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
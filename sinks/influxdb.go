@@ -0,0 +1,134 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/szemin-ng/purecloud2odbc/internal/store"
+)
+
+// InfluxDBConfig configures an InfluxDBSink.
+type InfluxDBConfig struct {
+	URL       string // base URL of the InfluxDB server, e.g. http://localhost:8086
+	Database  string
+	BatchSize int // number of points to buffer before an automatic Flush; 0 disables auto-flush
+}
+
+// InfluxDBSink batches queue interval statistics as line protocol and writes them to InfluxDB
+// over HTTP, gzip-compressed.
+type InfluxDBSink struct {
+	cfg    InfluxDBConfig
+	client *http.Client
+	buf    bytes.Buffer
+	count  int
+}
+
+// NewInfluxDBSink returns a Sink that writes line protocol to the InfluxDB server described by cfg.
+func NewInfluxDBSink(cfg InfluxDBConfig) *InfluxDBSink {
+	return &InfluxDBSink{cfg: cfg, client: &http.Client{}}
+}
+
+// WriteQueueInterval appends rec to the pending batch as a line protocol point, flushing
+// automatically once BatchSize points have been buffered.
+func (s *InfluxDBSink) WriteQueueInterval(ctx context.Context, rec store.QueueIntervalRecord) error {
+	writeLineProtocol(&s.buf, rec)
+	s.count++
+
+	if s.cfg.BatchSize > 0 && s.count >= s.cfg.BatchSize {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush gzip-compresses the pending batch and POSTs it to InfluxDB's /write endpoint.
+func (s *InfluxDBSink) Flush(ctx context.Context) error {
+	if s.count == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.cfg.URL, "/")+"/write?precision=s&db="+url.QueryEscape(s.cfg.Database), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("influxdb write to %s failed with status %s", s.cfg.URL, resp.Status)
+	}
+
+	s.buf.Reset()
+	s.count = 0
+	return nil
+}
+
+// Close is a no-op; InfluxDBSink holds no resources beyond its HTTP client.
+func (s *InfluxDBSink) Close() error {
+	return nil
+}
+
+// writeLineProtocol appends rec to buf as a single InfluxDB line protocol point, e.g.
+// queue_interval,queue=Support,queueId=c2788c7e-...,mediaType=voice nOffered=12i,tAcd=345.6 1609459200
+func writeLineProtocol(buf *bytes.Buffer, rec store.QueueIntervalRecord) {
+	buf.WriteString("queue_interval,queue=")
+	buf.WriteString(escapeTagValue(rec.QueueName))
+	buf.WriteString(",queueId=")
+	buf.WriteString(escapeTagValue(rec.QueueID))
+	buf.WriteString(",mediaType=")
+	buf.WriteString(escapeTagValue(rec.MediaType))
+	buf.WriteByte(' ')
+
+	first := true
+	writeField := func(name string, value string) {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+	}
+
+	for name, count := range rec.Counts {
+		writeField(name, strconv.Itoa(count)+"i")
+	}
+	for name, m := range rec.Metrics {
+		writeField("t"+name, strconv.FormatFloat(m.Sum, 'f', -1, 64))
+		writeField("mt"+name, strconv.FormatFloat(m.Max, 'f', -1, 64))
+		writeField("n"+name, strconv.Itoa(m.Count)+"i")
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(rec.Interval.Unix(), 10))
+	buf.WriteByte('\n')
+}
+
+// escapeTagValue escapes the characters line protocol treats as delimiters within tag values.
+func escapeTagValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, ",", `\,`)
+	v = strings.ReplaceAll(v, "=", `\=`)
+	v = strings.ReplaceAll(v, " ", `\ `)
+	return v
+}
@@ -0,0 +1,53 @@
+package sinks
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/szemin-ng/purecloud2odbc/internal/store"
+)
+
+// ODBCSink writes queue interval statistics into a SQL database via a store.StatsWriter.
+// Despite the name it is used for every SQL store.Driver, not just ODBC.
+type ODBCSink struct {
+	writer *store.StatsWriter
+	began  bool
+}
+
+// NewODBCSink returns a Sink that writes to db using the SQL dialect for driver.
+func NewODBCSink(db *sql.DB, driver store.Driver) *ODBCSink {
+	return &ODBCSink{writer: store.NewStatsWriter(db, driver)}
+}
+
+// WriteQueueInterval upserts rec, starting the underlying transaction on the first call. If the
+// upsert fails, the transaction is rolled back immediately rather than left open: runDaemon
+// reuses the same Sink across every poll tick, so a transaction abandoned here would otherwise
+// silently fail every subsequent write until the process is restarted.
+func (s *ODBCSink) WriteQueueInterval(ctx context.Context, rec store.QueueIntervalRecord) error {
+	if !s.began {
+		if err := s.writer.Begin(); err != nil {
+			return err
+		}
+		s.began = true
+	}
+	if err := s.writer.WriteQueueInterval(rec); err != nil {
+		s.writer.Rollback()
+		s.began = false
+		return err
+	}
+	return nil
+}
+
+// Flush commits the transaction started by WriteQueueInterval.
+func (s *ODBCSink) Flush(ctx context.Context) error {
+	if !s.began {
+		return nil
+	}
+	s.began = false
+	return s.writer.Commit()
+}
+
+// Close is a no-op; the underlying *sql.DB is owned and closed by the caller.
+func (s *ODBCSink) Close() error {
+	return nil
+}
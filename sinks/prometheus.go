@@ -0,0 +1,162 @@
+package sinks
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/szemin-ng/purecloud2odbc/internal/store"
+)
+
+var queueIntervalLabels = []string{"queue", "queueId", "mediaType"}
+
+// queueIntervalKey identifies the (queue, mediaType, interval) a set of counter values was last
+// applied for, so a re-fetch of an already-applied interval can be told apart from a new one.
+type queueIntervalKey struct {
+	queueID   string
+	mediaType string
+	interval  time.Time
+}
+
+// PrometheusSink exposes queue interval statistics as Prometheus counters scraped from
+// ListenAddr/metrics, instead of pushing them to an external system.
+type PrometheusSink struct {
+	server *http.Server
+
+	counts  map[string]*prometheus.CounterVec // countColumn -> purecloud_queue_<countColumn>_total
+	sums    map[string]*prometheus.CounterVec // metric -> purecloud_queue_<metric>_seconds_total
+	maxes   map[string]*prometheus.GaugeVec   // metric -> purecloud_queue_<metric>_max_seconds
+	metricN map[string]*prometheus.CounterVec // metric -> purecloud_queue_<metric>_count_total
+
+	// mu guards last. Daemon mode always re-fetches and re-writes the previous interval on
+	// every tick (see runDaemon), which is safe for the SQL sinks since they upsert, but a
+	// Prometheus Counter only ever goes up: applying the same interval's full value again
+	// would inflate it without bound. last remembers the cumulative value most recently
+	// applied for each (queue, mediaType, interval, column), so a re-fetch adds only the
+	// delta since last time instead of the whole value again.
+	//
+	// retention bounds how long an interval's entry is kept: daemon mode never re-fetches
+	// further back than LookbackWindow, so on each write, entries older than retention before
+	// the interval being written are evicted, keeping last from growing for as long as the
+	// daemon runs.
+	mu        sync.Mutex
+	last      map[queueIntervalKey]map[string]float64
+	retention time.Duration
+}
+
+// NewPrometheusSink starts an HTTP server on listenAddr serving /metrics and returns a Sink
+// that updates its gauges/counters as queue interval statistics arrive. retention bounds how
+// long per-interval state is kept for delta tracking; pass the daemon's LookbackWindow, since
+// it never re-fetches an interval older than that.
+func NewPrometheusSink(listenAddr string, retention time.Duration) *PrometheusSink {
+	s := &PrometheusSink{
+		counts:    make(map[string]*prometheus.CounterVec),
+		sums:      make(map[string]*prometheus.CounterVec),
+		maxes:     make(map[string]*prometheus.GaugeVec),
+		metricN:   make(map[string]*prometheus.CounterVec),
+		last:      make(map[queueIntervalKey]map[string]float64),
+		retention: retention,
+	}
+
+	for _, name := range []string{"nError", "nOffered", "nOutboundAbandoned", "nOutboundAttempted", "nOutboundConnected", "nTransferred", "nOverSla"} {
+		s.counts[name] = promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "purecloud_queue_" + name + "_total",
+			Help: "Total " + name + " reported per queue interval.",
+		}, queueIntervalLabels)
+	}
+
+	for _, name := range []string{"Abandon", "Acd", "Acw", "AgentResponseTime", "Answered", "Handle", "Held", "HeldComplete", "Ivr", "Talk", "TalkComplete", "UserResponseTime"} {
+		s.sums[name] = promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "purecloud_queue_" + name + "_seconds_total",
+			Help: "Total " + name + " time in seconds reported per queue interval.",
+		}, queueIntervalLabels)
+		s.maxes[name] = promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "purecloud_queue_" + name + "_max_seconds",
+			Help: "Maximum " + name + " time in seconds reported in the most recent queue interval.",
+		}, queueIntervalLabels)
+		s.metricN[name] = promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "purecloud_queue_" + name + "_count_total",
+			Help: "Number of interactions contributing to " + name + " reported per queue interval.",
+		}, queueIntervalLabels)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s.server = &http.Server{Addr: listenAddr, Handler: mux}
+	go s.server.ListenAndServe()
+
+	return s
+}
+
+// WriteQueueInterval updates every gauge/counter for rec's (queue, queueId, mediaType) labels.
+// A re-fetch of an interval already applied for this key only adds the delta since last time,
+// so daemon mode's habitual re-write of the previous interval doesn't inflate the counters.
+func (s *PrometheusSink) WriteQueueInterval(ctx context.Context, rec store.QueueIntervalRecord) error {
+	labels := prometheus.Labels{"queue": rec.QueueName, "queueId": rec.QueueID, "mediaType": rec.MediaType}
+	key := queueIntervalKey{queueID: rec.QueueID, mediaType: rec.MediaType, interval: rec.Interval}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.last[key]
+	if !ok {
+		prev = make(map[string]float64)
+		s.last[key] = prev
+	}
+
+	// Only ratchet prev[column] up when the delta is actually applied, never down: a
+	// transient dip in an eventually-consistent re-fetch must not lower the baseline, or a
+	// later fetch settling back to the true (higher) value would re-add the gap already
+	// counted and inflate the counter beyond the real cumulative total.
+	addDelta := func(column string, value float64, counter prometheus.Counter) {
+		if delta := value - prev[column]; delta > 0 {
+			counter.Add(delta)
+			prev[column] = value
+		}
+	}
+
+	for name, count := range rec.Counts {
+		if c, ok := s.counts[name]; ok {
+			addDelta(name, float64(count), c.With(labels))
+		}
+	}
+
+	for name, m := range rec.Metrics {
+		sum, ok := s.sums[name]
+		if !ok {
+			Logger.Warn().Str("metric", name).Msg("prometheus sink: skipping unknown metric not registered in NewPrometheusSink")
+			continue
+		}
+		addDelta("sum:"+name, m.Sum, sum.With(labels))
+		s.maxes[name].With(labels).Set(m.Max)
+		addDelta("n:"+name, float64(m.Count), s.metricN[name].With(labels))
+	}
+
+	s.evictStaleLocked(rec.Interval.Add(-s.retention))
+
+	return nil
+}
+
+// evictStaleLocked removes last entries older than cutoff. Must be called with mu held.
+func (s *PrometheusSink) evictStaleLocked(cutoff time.Time) {
+	for key := range s.last {
+		if key.interval.Before(cutoff) {
+			delete(s.last, key)
+		}
+	}
+}
+
+// Flush is a no-op; PrometheusSink is scraped rather than pushed to.
+func (s *PrometheusSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close shuts down the /metrics HTTP server.
+func (s *PrometheusSink) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,30 @@
+// Package sinks lets queue interval statistics be delivered to more than just the ODBC
+// database: the same QueueIntervalRecord can be pushed into InfluxDB, exposed to Prometheus,
+// or written to SQL, behind a single Sink interface.
+package sinks
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"github.com/szemin-ng/purecloud2odbc/internal/store"
+)
+
+// Logger is the structured logger sinks write through. It defaults to a disabled logger so the
+// package is usable without setup; callers wire it up to their own logger (main does this in
+// setupLogging) to get sink log lines tagged with the rest of the app's fields (e.g. run_id).
+var Logger = zerolog.Nop()
+
+// Sink receives queue interval statistics and delivers them to a downstream system.
+type Sink interface {
+	// WriteQueueInterval delivers a single queue interval record. Implementations may buffer
+	// records rather than delivering them immediately.
+	WriteQueueInterval(ctx context.Context, rec store.QueueIntervalRecord) error
+
+	// Flush delivers any records buffered by WriteQueueInterval.
+	Flush(ctx context.Context) error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
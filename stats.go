@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// statsReportInterval is how often startStatsReporter logs a throughput snapshot.
+const statsReportInterval = 10 * time.Second
+
+// runStats accumulates counters for the periodic stats reporter goroutine. Counters are only
+// ever mutated through their add methods, since the reporter goroutine reads them while the
+// goroutine doing the polling updates them.
+type runStats struct {
+	intervalsProcessed int64
+	rowsWritten        int64
+	apiCallsMade       int64
+}
+
+func (s *runStats) addIntervalsProcessed(n int64) { atomic.AddInt64(&s.intervalsProcessed, n) }
+func (s *runStats) addRowsWritten(n int64)        { atomic.AddInt64(&s.rowsWritten, n) }
+func (s *runStats) addAPICallsMade(n int64)       { atomic.AddInt64(&s.apiCallsMade, n) }
+
+var stats runStats
+
+// startStatsReporter logs a rows/sec throughput snapshot every statsReportInterval, similar to
+// the "X records (Y/sec)" snapshots blob-fetching daemons print, until stopCh is closed.
+func startStatsReporter(stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(statsReportInterval)
+		defer ticker.Stop()
+
+		var lastRowsWritten int64
+		for {
+			select {
+			case <-ticker.C:
+				rowsWritten := atomic.LoadInt64(&stats.rowsWritten)
+				rowsPerSec := float64(rowsWritten-lastRowsWritten) / statsReportInterval.Seconds()
+				lastRowsWritten = rowsWritten
+
+				logger.Info().
+					Int64("intervals_processed", atomic.LoadInt64(&stats.intervalsProcessed)).
+					Int64("rows_written", rowsWritten).
+					Int64("api_calls_made", atomic.LoadInt64(&stats.apiCallsMade)).
+					Float64("rows_per_sec", rowsPerSec).
+					Msg("stats")
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}